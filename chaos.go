@@ -0,0 +1,104 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "math/rand"
+    "strings"
+    "time"
+)
+
+// chaosConfig configures synthetic failure injection around a Doer, purely
+// client-side, so users can validate their retry/backoff logic (and this
+// tool's own accounting) without needing an actually-unstable target.
+type chaosConfig struct {
+    failRate      float64 // fraction of requests that short-circuit with a synthetic 5xx
+    resetRate     float64 // fraction of requests that short-circuit with a connection-reset error
+    latencyMean   time.Duration
+    latencyStddev time.Duration // 0 means fixed latency, no jitter
+}
+
+func (c chaosConfig) enabled() bool {
+    return c.failRate > 0 || c.resetRate > 0 || c.latencyMean > 0 || c.latencyStddev > 0
+}
+
+// parseChaosLatency parses flags of the form "50ms" (fixed) or "50ms±20ms"
+// (normally distributed around the mean) into a mean and stddev.
+func parseChaosLatency(s string) (mean, stddev time.Duration, err error) {
+    if s == "" {
+        return 0, 0, nil
+    }
+    parts := strings.SplitN(s, "±", 2)
+    mean, err = time.ParseDuration(strings.TrimSpace(parts[0]))
+    if err != nil {
+        return 0, 0, fmt.Errorf("invalid -chaos.latency mean %q: %w", parts[0], err)
+    }
+    if len(parts) == 2 {
+        stddev, err = time.ParseDuration(strings.TrimSpace(parts[1]))
+        if err != nil {
+            return 0, 0, fmt.Errorf("invalid -chaos.latency stddev %q: %w", parts[1], err)
+        }
+    }
+    return mean, stddev, nil
+}
+
+// chaosResetError mimics the error net/http returns for a connection reset
+// by the peer, so client-side retry logic sees a realistic failure shape.
+type chaosResetError struct{}
+
+func (chaosResetError) Error() string { return "read: connection reset by peer (chaos-injected)" }
+
+// chaosDoer wraps another Doer and injects synthetic failures before (or
+// instead of) forwarding the request, without ever touching the real
+// target server.
+type chaosDoer struct {
+    inner Doer
+    cfg   chaosConfig
+}
+
+func newChaosDoer(inner Doer, cfg chaosConfig) *chaosDoer {
+    return &chaosDoer{inner: inner, cfg: cfg}
+}
+
+// sleep blocks for the configured artificial latency and reports whether
+// the context was cancelled first, so a long enough injected delay can
+// simulate a forced timeout against the caller's own deadline.
+func (c *chaosDoer) sleep(ctx context.Context) error {
+    if c.cfg.latencyMean <= 0 {
+        return nil
+    }
+    delay := c.cfg.latencyMean
+    if c.cfg.latencyStddev > 0 {
+        jittered := rand.NormFloat64()*float64(c.cfg.latencyStddev) + float64(c.cfg.latencyMean)
+        if jittered < 0 {
+            jittered = 0
+        }
+        delay = time.Duration(jittered)
+    }
+    select {
+    case <-time.After(delay):
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+func (c *chaosDoer) Do(ctx context.Context, method, url string, headers map[string]string, body []byte) (*DoerResponse, error) {
+    if err := c.sleep(ctx); err != nil {
+        return nil, err
+    }
+
+    if c.cfg.resetRate > 0 && rand.Float64() < c.cfg.resetRate {
+        return nil, chaosResetError{}
+    }
+    if c.cfg.failRate > 0 && rand.Float64() < c.cfg.failRate {
+        code := 500 + rand.Intn(5) // 500-504
+        return &DoerResponse{StatusCode: code, Status: fmt.Sprintf("%d Chaos Injected", code)}, nil
+    }
+
+    return c.inner.Do(ctx, method, url, headers, body)
+}
+
+func (c *chaosDoer) Close() {
+    c.inner.Close()
+}
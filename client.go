@@ -0,0 +1,281 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "sync/atomic"
+    "time"
+
+    "github.com/valyala/fasthttp"
+    "golang.org/x/net/http2"
+)
+
+// DoerResponse is the transport-agnostic result of a single request, shared
+// by every Doer implementation so makeRequest doesn't need to know which
+// client backend produced it.
+type DoerResponse struct {
+    StatusCode int
+    Status     string
+    Body       []byte
+}
+
+// Doer abstracts the HTTP transport so makeRequest can run against
+// net/http, fasthttp or an HTTP/2-only client without caring which one was
+// selected on the command line.
+type Doer interface {
+    Do(ctx context.Context, method, url string, headers map[string]string, body []byte) (*DoerResponse, error)
+    Close()
+}
+
+// connCounter accumulates bytes read/written and connections opened across
+// every request issued by a Doer, regardless of backend, so the final
+// report can show throughput instead of dropping it on the floor.
+type connCounter struct {
+    bytesRead    int64
+    bytesWritten int64
+    connsOpened  int64
+}
+
+func (c *connCounter) BytesRead() int64    { return atomic.LoadInt64(&c.bytesRead) }
+func (c *connCounter) BytesWritten() int64 { return atomic.LoadInt64(&c.bytesWritten) }
+func (c *connCounter) ConnsOpened() int64  { return atomic.LoadInt64(&c.connsOpened) }
+
+// countingConn wraps a net.Conn and feeds every Read/Write into a shared
+// connCounter.
+type countingConn struct {
+    net.Conn
+    counter *connCounter
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+    n, err := c.Conn.Read(b)
+    atomic.AddInt64(&c.counter.bytesRead, int64(n))
+    return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+    n, err := c.Conn.Write(b)
+    atomic.AddInt64(&c.counter.bytesWritten, int64(n))
+    return n, err
+}
+
+func dialCounting(counter *connCounter, dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+    return func(ctx context.Context, network, addr string) (net.Conn, error) {
+        conn, err := dialer.DialContext(ctx, network, addr)
+        if err != nil {
+            return nil, err
+        }
+        atomic.AddInt64(&counter.connsOpened, 1)
+        return &countingConn{Conn: conn, counter: counter}, nil
+    }
+}
+
+// netDoer is the default backend, built on net/http.
+type netDoer struct {
+    client  *http.Client
+    counter *connCounter
+}
+
+func newNetDoer(timeout time.Duration, counter *connCounter) *netDoer {
+    dialer := &net.Dialer{}
+    tr := &http.Transport{
+        MaxIdleConns:       10,
+        IdleConnTimeout:    30 * time.Second,
+        DisableCompression: true,
+        DialContext:        dialCounting(counter, dialer),
+    }
+    return &netDoer{
+        client:  &http.Client{Transport: tr, Timeout: timeout},
+        counter: counter,
+    }
+}
+
+func (d *netDoer) Do(ctx context.Context, method, url string, headers map[string]string, body []byte) (*DoerResponse, error) {
+    var reqBody io.Reader
+    if len(body) > 0 {
+        reqBody = bytes.NewReader(body)
+    }
+    req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+    if err != nil {
+        return nil, err
+    }
+    for key, value := range headers {
+        req.Header.Set(key, value)
+    }
+
+    resp, err := d.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    respBody, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+
+    return &DoerResponse{StatusCode: resp.StatusCode, Status: resp.Status, Body: respBody}, nil
+}
+
+func (d *netDoer) Close() {
+    d.client.CloseIdleConnections()
+}
+
+// h2Doer forces HTTP/2 over TLS (or h2c-style prior-knowledge cleartext is
+// intentionally not supported here), built on golang.org/x/net/http2.
+type h2Doer struct {
+    client  *http.Client
+    counter *connCounter
+}
+
+func newH2Doer(timeout time.Duration, counter *connCounter) *h2Doer {
+    dialer := &net.Dialer{}
+    tr := &http2.Transport{
+        DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+            rawConn, err := dialCounting(counter, dialer)(ctx, network, addr)
+            if err != nil {
+                return nil, err
+            }
+            tlsConn := tls.Client(rawConn, cfg)
+            if err := tlsConn.HandshakeContext(ctx); err != nil {
+                return nil, err
+            }
+            return tlsConn, nil
+        },
+    }
+    return &h2Doer{
+        client:  &http.Client{Transport: tr, Timeout: timeout},
+        counter: counter,
+    }
+}
+
+func (d *h2Doer) Do(ctx context.Context, method, url string, headers map[string]string, body []byte) (*DoerResponse, error) {
+    var reqBody io.Reader
+    if len(body) > 0 {
+        reqBody = bytes.NewReader(body)
+    }
+    req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+    if err != nil {
+        return nil, err
+    }
+    for key, value := range headers {
+        req.Header.Set(key, value)
+    }
+
+    resp, err := d.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    respBody, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+
+    return &DoerResponse{StatusCode: resp.StatusCode, Status: resp.Status, Body: respBody}, nil
+}
+
+func (d *h2Doer) Close() {
+    d.client.CloseIdleConnections()
+}
+
+// fastDoer is built on valyala/fasthttp, reusing pooled request/response
+// objects to avoid the per-request allocations net/http incurs.
+type fastDoer struct {
+    client  *fasthttp.Client
+    timeout time.Duration
+    counter *connCounter
+}
+
+func newFastDoer(timeout time.Duration, counter *connCounter) *fastDoer {
+    dialer := &net.Dialer{}
+    return &fastDoer{
+        timeout: timeout,
+        counter: counter,
+        client: &fasthttp.Client{
+            Dial: func(addr string) (net.Conn, error) {
+                conn, err := dialer.Dial("tcp", addr)
+                if err != nil {
+                    return nil, err
+                }
+                atomic.AddInt64(&counter.connsOpened, 1)
+                return &countingConn{Conn: conn, counter: counter}, nil
+            },
+        },
+    }
+}
+
+func (d *fastDoer) Do(ctx context.Context, method, url string, headers map[string]string, body []byte) (*DoerResponse, error) {
+    req := fasthttp.AcquireRequest()
+    resp := fasthttp.AcquireResponse()
+
+    req.SetRequestURI(url)
+    req.Header.SetMethod(method)
+    for key, value := range headers {
+        req.Header.Set(key, value)
+    }
+    if len(body) > 0 {
+        req.SetBody(body)
+    }
+
+    // fasthttp.Client has no context-aware Do, so race DoDeadline against
+    // ctx.Done() ourselves to honor runCtx cancellation (e.g. the graceful
+    // shutdown path) the same way the net/h2 backends do.
+    done := make(chan error, 1)
+    go func() {
+        done <- d.client.DoDeadline(req, resp, time.Now().Add(d.timeout))
+    }()
+
+    select {
+    case err := <-done:
+        defer fasthttp.ReleaseRequest(req)
+        defer fasthttp.ReleaseResponse(resp)
+        if err != nil {
+            return nil, err
+        }
+        // resp.Body() is only valid until the response is released, so copy it.
+        respBody := append([]byte(nil), resp.Body()...)
+        return &DoerResponse{
+            StatusCode: resp.StatusCode(),
+            Status:     fmt.Sprintf("%d %s", resp.StatusCode(), http.StatusText(resp.StatusCode())),
+            Body:       respBody,
+        }, nil
+    case <-ctx.Done():
+        // DoDeadline is still running against req/resp; release them once it
+        // actually returns instead of racing that release against fasthttp's
+        // own use of the objects.
+        go func() {
+            <-done
+            fasthttp.ReleaseRequest(req)
+            fasthttp.ReleaseResponse(resp)
+        }()
+        return nil, ctx.Err()
+    }
+}
+
+func (d *fastDoer) Close() {
+    d.client.CloseIdleConnections()
+}
+
+// newDoer builds the Doer selected by -client, sharing a single connCounter
+// across every request so the final report can add up bytes in/out
+// regardless of backend.
+func newDoer(kind string, timeout time.Duration, counter *connCounter) (Doer, error) {
+    switch kind {
+    case "", "net":
+        return newNetDoer(timeout, counter), nil
+    case "fast":
+        return newFastDoer(timeout, counter), nil
+    case "h2":
+        return newH2Doer(timeout, counter), nil
+    default:
+        return nil, fmt.Errorf("unknown -client backend %q (want net, fast or h2)", kind)
+    }
+}
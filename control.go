@@ -0,0 +1,132 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sync/atomic"
+
+    "golang.org/x/time/rate"
+)
+
+// controlServer is a small local HTTP server that lets an operator inspect
+// and retune a run while it's in progress, instead of only seeing results
+// once the process exits.
+type controlServer struct {
+    srv      *http.Server
+    stats    *Statistics
+    gate     *concurrencyGate
+    limiter  *rate.Limiter
+    inflight *int64
+}
+
+func newControlServer(addr string, stats *Statistics, gate *concurrencyGate, limiter *rate.Limiter, inflight *int64) *controlServer {
+    c := &controlServer{stats: stats, gate: gate, limiter: limiter, inflight: inflight}
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/stats", c.handleStats)
+    mux.HandleFunc("/metrics", c.handleMetrics)
+    mux.HandleFunc("/rate", c.handleSetRate)
+    mux.HandleFunc("/concurrency", c.handleSetConcurrency)
+
+    c.srv = &http.Server{Addr: addr, Handler: mux}
+    return c
+}
+
+// Start runs the control server in the background. ListenAndServe errors
+// other than the expected shutdown error are logged to stderr.
+func (c *controlServer) Start() {
+    go func() {
+        if err := c.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            fmt.Printf("control server error: %v\n", err)
+        }
+    }()
+}
+
+func (c *controlServer) Shutdown(ctx context.Context) error {
+    return c.srv.Shutdown(ctx)
+}
+
+func (c *controlServer) handleStats(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(c.stats.Snapshot())
+}
+
+func (c *controlServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+    snap := c.stats.Snapshot()
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+    fmt.Fprintln(w, "# HELP http_generator_requests_total Completed requests")
+    fmt.Fprintln(w, "# TYPE http_generator_requests_total counter")
+    fmt.Fprintf(w, "http_generator_requests_total %d\n", snap.RequestCount)
+
+    fmt.Fprintln(w, "# HELP http_generator_failures_total Failed requests")
+    fmt.Fprintln(w, "# TYPE http_generator_failures_total counter")
+    fmt.Fprintf(w, "http_generator_failures_total %d\n", snap.FailureCount)
+
+    fmt.Fprintln(w, "# HELP http_generator_inflight In-flight requests")
+    fmt.Fprintln(w, "# TYPE http_generator_inflight gauge")
+    fmt.Fprintf(w, "http_generator_inflight %d\n", atomic.LoadInt64(c.inflight))
+
+    fmt.Fprintln(w, "# HELP http_generator_latency_seconds Request latency histogram")
+    fmt.Fprintln(w, "# TYPE http_generator_latency_seconds histogram")
+    var cumulative int64
+    for i := 0; i < histogramBuckets; i++ {
+        count := atomic.LoadInt64(&c.stats.buckets[i])
+        if count == 0 {
+            continue
+        }
+        cumulative += count
+        fmt.Fprintf(w, "http_generator_latency_seconds_bucket{le=\"%g\"} %d\n", durationForBucket(i).Seconds(), cumulative)
+    }
+    // Prometheus requires every histogram to end with a +Inf bucket equal to
+    // the total count, or histogram_quantile()/promtool reject the output.
+    fmt.Fprintf(w, "http_generator_latency_seconds_bucket{le=\"+Inf\"} %d\n", snap.RequestCount)
+    fmt.Fprintf(w, "http_generator_latency_seconds_sum %g\n", snap.Mean.Seconds()*float64(snap.RequestCount))
+    fmt.Fprintf(w, "http_generator_latency_seconds_count %d\n", snap.RequestCount)
+}
+
+type rateRequest struct {
+    Rate float64 `json:"rate"`
+}
+
+func (c *controlServer) handleSetRate(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "POST required", http.StatusMethodNotAllowed)
+        return
+    }
+    if c.limiter == nil {
+        http.Error(w, "no rate limiter configured for this run (start with -rate to enable)", http.StatusConflict)
+        return
+    }
+    var req rateRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+        return
+    }
+    c.limiter.SetLimit(rate.Limit(req.Rate))
+    w.WriteHeader(http.StatusNoContent)
+}
+
+type concurrencyRequest struct {
+    Concurrency int `json:"concurrency"`
+}
+
+func (c *controlServer) handleSetConcurrency(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "POST required", http.StatusMethodNotAllowed)
+        return
+    }
+    var req concurrencyRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+        return
+    }
+    if req.Concurrency <= 0 {
+        http.Error(w, "concurrency must be positive", http.StatusBadRequest)
+        return
+    }
+    c.gate.SetLimit(req.Concurrency)
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -1,199 +1,419 @@
-package main
-
-import (
-    "bytes"
-    "context"
-    "encoding/json"
-    "flag"
-    "fmt"
-    "io/ioutil"
-    "net/http"
-    "os"
-    "strings"
-    "sync"
-    "time"
-)
-
-type RequestResult struct {
-    Status         string        `json:"status"`
-    Duration       time.Duration `json:"duration"`
-    ResponseLength int           `json:"response_length"`
-    Error          string        `json:"error,omitempty"`
-}
-
-func makeRequest(ctx context.Context, client *http.Client, method string, url string, body string, headers map[string]string, wg *sync.WaitGroup, semaphore chan struct{}, results chan<- RequestResult, stats *Statistics, logFile *os.File) {
-    defer wg.Done()
-    defer func() { <-semaphore }() // Release the semaphore
-
-    var req *http.Request
-    var err error
-    if method == "POST" || method == "PUT" || method == "PATCH" {
-        req, err = http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer([]byte(body)))
-    } else {
-        req, err = http.NewRequestWithContext(ctx, method, url, nil)
-    }
-    if err != nil {
-        logError(logFile, fmt.Sprintf("Error creating request: %v", err))
-        results <- RequestResult{Error: err.Error()}
-        stats.IncrementFailures()
-        return
-    }
-    for key, value := range headers {
-        req.Header.Set(key, value)
-    }
-
-    startTime := time.Now()
-    resp, err := client.Do(req)
-    duration := time.Since(startTime)
-
-    if err != nil {
-        logError(logFile, fmt.Sprintf("Error: %v", err))
-        results <- RequestResult{Error: err.Error()}
-        stats.IncrementFailures()
-        return
-    }
-    defer resp.Body.Close()
-
-    bodyBytes, err := ioutil.ReadAll(resp.Body)
-    if err != nil {
-        logError(logFile, fmt.Sprintf("Error reading response body: %v", err))
-        results <- RequestResult{Error: err.Error()}
-        stats.IncrementFailures()
-        return
-    }
-
-    result := RequestResult{
-        Status:         resp.Status,
-        Duration:       duration,
-        ResponseLength: len(bodyBytes),
-    }
-    results <- result
-    stats.AddDuration(duration)
-    stats.IncrementRequests()
-}
-
-func logError(logFile *os.File, message string) {
-    logFile.WriteString(fmt.Sprintf("%s: %s\n", time.Now().Format(time.RFC3339), message))
-}
-
-type Statistics struct {
-    sync.Mutex
-    TotalDuration time.Duration
-    RequestCount  int
-    FailureCount  int
-}
-
-func (s *Statistics) AddDuration(duration time.Duration) {
-    s.Lock()
-    defer s.Unlock()
-    s.TotalDuration += duration
-}
-
-func (s *Statistics) IncrementRequests() {
-    s.Lock()
-    defer s.Unlock()
-    s.RequestCount++
-}
-
-func (s *Statistics) IncrementFailures() {
-    s.Lock()
-    defer s.Unlock()
-    s.FailureCount++
-}
-
-func (s *Statistics) AverageDuration() time.Duration {
-    s.Lock()
-    defer s.Unlock()
-    if s.RequestCount == 0 {
-        return 0
-    }
-    return s.TotalDuration / time.Duration(s.RequestCount)
-}
-
-func writeResults(results <-chan RequestResult, outputFile *os.File, wg *sync.WaitGroup) {
-    defer wg.Done()
-    encoder := json.NewEncoder(outputFile)
-    for result := range results {
-        if err := encoder.Encode(result); err != nil {
-            fmt.Fprintf(os.Stderr, "Error writing result: %v\n", err)
-        }
-    }
-}
-
-func main() {
-    url := flag.String("url", "http://example.com", "URL to send requests to")
-    numRequests := flag.Int("n", 10, "Number of requests")
-    method := flag.String("method", "GET", "HTTP method (GET, POST, PUT, DELETE, PATCH, HEAD, OPTIONS, TRACE)")
-    body := flag.String("body", "", "Request body (for POST, PUT, PATCH method)")
-    timeout := flag.Int("timeout", 10, "Request timeout in seconds")
-    headersFlag := flag.String("headers", "", "Custom headers (format: key1=value1,key2=value2)")
-    interval := flag.Int("interval", 0, "Interval between requests in milliseconds")
-    outputFile := flag.String("output", "results.json", "Output file to save results")
-    errorFile := flag.String("errorlog", "errors.log", "File to log errors")
-    maxConcurrentRequests := flag.Int("maxconcurrent", 5, "Maximum number of concurrent requests")
-    flag.Parse()
-
-    headers := make(map[string]string)
-    if *headersFlag != "" {
-        headersPairs := strings.Split(*headersFlag, ",")
-        for _, pair := range headersPairs {
-            kv := strings.SplitN(pair, "=", 2)
-            if len(kv) == 2) {
-                headers[kv[0]] = kv[1]
-            }
-        }
-    }
-
-    tr := &http.Transport{
-        MaxIdleConns:       10,
-        IdleConnTimeout:    30 * time.Second,
-        DisableCompression: true,
-    }
-    client := &http.Client{
-        Transport: tr,
-        Timeout:   time.Duration(*timeout) * time.Second,
-    }
-
-    var wg sync.WaitGroup
-    results := make(chan RequestResult, *numRequests)
-    stats := &Statistics{}
-    semaphore := make(chan struct{}, *maxConcurrentRequests)
-
-    outputFileHandle, err := os.OpenFile(*outputFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-    if err != nil {
-        fmt.Println("Error opening output file:", err)
-        return
-    }
-    defer outputFileHandle.Close()
-
-    errorFileHandle, err := os.OpenFile(*errorFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-    if err != nil {
-        fmt.Println("Error opening error log file:", err)
-        return
-    }
-    defer errorFileHandle.Close()
-
-    wg.Add(*numRequests)
-    for i := 0; i < *numRequests; i++ {
-        semaphore <- struct{}{} // Acquire semaphore
-        ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*timeout)*time.Second)
-        defer cancel()
-        go makeRequest(ctx, client, *method, *url, *body, headers, &wg, semaphore, results, stats, errorFileHandle)
-        if *interval > 0 {
-            time.Sleep(time.Duration(*interval) * time.Millisecond)
-        }
-    }
-
-    var writeWg sync.WaitGroup
-    writeWg.Add(1)
-    go writeResults(results, outputFileHandle, &writeWg)
-
-    wg.Wait()
-    close(results)
-    writeWg.Wait()
-
-    fmt.Println("All requests completed")
-    fmt.Printf("Total requests: %d\n", stats.RequestCount)
-    fmt.Printf("Failed requests: %d\n", stats.FailureCount)
-    fmt.Printf("Average response time: %v\n", stats.AverageDuration())
-}
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/signal"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "syscall"
+    "time"
+
+    "golang.org/x/time/rate"
+)
+
+type RequestResult struct {
+    Status         string        `json:"status"`
+    Duration       time.Duration `json:"duration"`
+    ResponseLength int           `json:"response_length"`
+    Error          string        `json:"error,omitempty"`
+}
+
+// concurrencyGate is a resizable counting semaphore. Unlike a plain buffered
+// channel, its capacity can be changed while goroutines are blocked on
+// Acquire, which is what lets ramp-up gradually raise concurrency over time.
+type concurrencyGate struct {
+    mu      sync.Mutex
+    cond    *sync.Cond
+    limit   int
+    current int
+}
+
+func newConcurrencyGate(limit int) *concurrencyGate {
+    g := &concurrencyGate{limit: limit}
+    g.cond = sync.NewCond(&g.mu)
+    return g
+}
+
+func (g *concurrencyGate) Acquire() {
+    g.mu.Lock()
+    for g.current >= g.limit {
+        g.cond.Wait()
+    }
+    g.current++
+    g.mu.Unlock()
+}
+
+func (g *concurrencyGate) Release() {
+    g.mu.Lock()
+    g.current--
+    g.cond.Signal()
+    g.mu.Unlock()
+}
+
+func (g *concurrencyGate) SetLimit(limit int) {
+    g.mu.Lock()
+    g.limit = limit
+    g.cond.Broadcast()
+    g.mu.Unlock()
+}
+
+// rampConfig describes how concurrency and request rate scale up from a
+// starting point to their target values over a warm-up window, instead of
+// hitting the target load as an immediate burst.
+type rampConfig struct {
+    mode             string // "none", "linear" or "step"
+    warmup           time.Duration
+    startConcurrency int
+    startRate        float64
+    steps            int
+}
+
+// runRamp gradually moves gate and limiter from the ramp's starting point up
+// to targetConcurrency/targetRate over cfg.warmup, then returns. It blocks
+// for the duration of the warm-up window and is meant to run in its own
+// goroutine alongside the worker loop.
+func runRamp(ctx context.Context, cfg rampConfig, gate *concurrencyGate, limiter *rate.Limiter, targetConcurrency int, targetRate float64) {
+    if cfg.mode == "none" || cfg.warmup <= 0 {
+        return
+    }
+
+    const tick = 200 * time.Millisecond
+    steps := cfg.steps
+    if cfg.mode == "linear" {
+        steps = int(cfg.warmup / tick)
+    }
+    if steps <= 0 {
+        steps = 1
+    }
+    stepInterval := cfg.warmup / time.Duration(steps)
+
+    for i := 1; i <= steps; i++ {
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(stepInterval):
+        }
+
+        frac := float64(i) / float64(steps)
+        concurrency := cfg.startConcurrency + int(frac*float64(targetConcurrency-cfg.startConcurrency))
+        gate.SetLimit(concurrency)
+
+        if limiter != nil {
+            r := cfg.startRate + frac*(targetRate-cfg.startRate)
+            limiter.SetLimit(rate.Limit(r))
+        }
+    }
+
+    gate.SetLimit(targetConcurrency)
+    if limiter != nil {
+        limiter.SetLimit(rate.Limit(targetRate))
+    }
+}
+
+func makeRequest(ctx context.Context, client Doer, method string, url string, body string, headers map[string]string, wg *sync.WaitGroup, gate *concurrencyGate, results chan<- RequestResult, stats *Statistics, logFile *os.File, inflight *int64) {
+    defer wg.Done()
+    defer gate.Release()
+    atomic.AddInt64(inflight, 1)
+    defer atomic.AddInt64(inflight, -1)
+
+    startTime := time.Now()
+    resp, err := client.Do(ctx, method, url, headers, []byte(body))
+    duration := time.Since(startTime)
+
+    if err != nil {
+        logError(logFile, fmt.Sprintf("Error: %v", err))
+        results <- RequestResult{Error: err.Error()}
+        stats.IncrementFailures()
+        return
+    }
+
+    result := RequestResult{
+        Status:         resp.Status,
+        Duration:       duration,
+        ResponseLength: len(resp.Body),
+    }
+    results <- result
+    stats.AddDuration(duration)
+    stats.IncrementRequests()
+    stats.AddBytes(int64(len(resp.Body)))
+    stats.RecordStatus(resp.StatusCode)
+}
+
+func logError(logFile *os.File, message string) {
+    logFile.WriteString(fmt.Sprintf("%s: %s\n", time.Now().Format(time.RFC3339), message))
+}
+
+func writeResults(results <-chan RequestResult, outputFile *os.File, wg *sync.WaitGroup) {
+    defer wg.Done()
+    encoder := json.NewEncoder(outputFile)
+    for result := range results {
+        if err := encoder.Encode(result); err != nil {
+            fmt.Fprintf(os.Stderr, "Error writing result: %v\n", err)
+        }
+    }
+}
+
+func main() {
+    url := flag.String("url", "http://example.com", "URL to send requests to")
+    numRequests := flag.Int("n", 10, "Number of requests (ignored if -duration is set)")
+    duration := flag.Duration("duration", 0, "Run for this long instead of a fixed request count (e.g. 30s, 5m)")
+    targetRate := flag.Float64("rate", 0, "Target requests/sec across all workers (0 = unlimited)")
+    method := flag.String("method", "GET", "HTTP method (GET, POST, PUT, DELETE, PATCH, HEAD, OPTIONS, TRACE)")
+    body := flag.String("body", "", "Request body (for POST, PUT, PATCH method)")
+    timeout := flag.Int("timeout", 10, "Request timeout in seconds")
+    headersFlag := flag.String("headers", "", "Custom headers (format: key1=value1,key2=value2)")
+    interval := flag.Int("interval", 0, "Interval between requests in milliseconds")
+    outputFile := flag.String("output", "results.json", "Output file to save results")
+    errorFile := flag.String("errorlog", "errors.log", "File to log errors")
+    maxConcurrentRequests := flag.Int("maxconcurrent", 5, "Maximum number of concurrent requests")
+    rampMode := flag.String("ramp", "none", "Ramp-up mode: none, linear, step")
+    rampDuration := flag.Duration("ramp-duration", 0, "Warm-up window over which concurrency/rate ramp to their targets")
+    rampStartConcurrency := flag.Int("ramp-start-concurrent", 1, "Starting concurrency for ramp-up")
+    rampStartRate := flag.Float64("ramp-start-rate", 0, "Starting request rate for ramp-up")
+    rampSteps := flag.Int("ramp-steps", 10, "Number of discrete jumps for step ramp-up mode")
+    showProgress := flag.Bool("progress", true, "Print a live RPS/error-rate/p99 line to stderr every 500ms")
+    summaryFile := flag.String("summary", "", "Write a final JSON statistics summary to this file (default: stdout)")
+    clientKind := flag.String("client", "net", "HTTP client backend: net, fast or h2")
+    scenarioFile := flag.String("scenario", "", "Run a YAML/JSON scenario file (a virtual-user request sequence) instead of hammering -url")
+    scenarioDataFile := flag.String("scenario-data", "", "CSV data source for the scenario, one row per virtual-user iteration")
+    controlAddr := flag.String("control", "", "Bind a local control server here (e.g. :8081) serving /stats, /metrics and POST /rate, /concurrency")
+    shutdownGrace := flag.Duration("shutdown-grace", 10*time.Second, "How long to wait for in-flight requests to drain after SIGINT/SIGTERM before printing a partial report")
+    chaosFailRate := flag.Float64("chaos.fail-rate", 0, "Fraction of requests (0-1) that short-circuit with a synthetic 5xx instead of hitting the target")
+    chaosResetRate := flag.Float64("chaos.reset-rate", 0, "Fraction of requests (0-1) that short-circuit with a simulated connection reset")
+    chaosLatency := flag.String("chaos.latency", "", "Artificial latency injected before every request, e.g. 50ms or 50ms±20ms for normally-distributed jitter")
+    flag.Parse()
+
+    headers := make(map[string]string)
+    if *headersFlag != "" {
+        headersPairs := strings.Split(*headersFlag, ",")
+        for _, pair := range headersPairs {
+            kv := strings.SplitN(pair, "=", 2)
+            if len(kv) == 2 {
+                headers[kv[0]] = kv[1]
+            }
+        }
+    }
+
+    transportCounter := &connCounter{}
+    client, err := newDoer(*clientKind, time.Duration(*timeout)*time.Second, transportCounter)
+    if err != nil {
+        fmt.Println("Error:", err)
+        return
+    }
+    defer client.Close()
+
+    chaosLatencyMean, chaosLatencyStddev, err := parseChaosLatency(*chaosLatency)
+    if err != nil {
+        fmt.Println("Error:", err)
+        return
+    }
+    chaos := chaosConfig{
+        failRate:      *chaosFailRate,
+        resetRate:     *chaosResetRate,
+        latencyMean:   chaosLatencyMean,
+        latencyStddev: chaosLatencyStddev,
+    }
+    if chaos.enabled() {
+        client = newChaosDoer(client, chaos)
+    }
+
+    stats := NewStatistics()
+    stats.AttachTransport(transportCounter)
+
+    var scenario *Scenario
+    var scenarioData *vuDataSource
+    if *scenarioFile != "" {
+        scenario, err = LoadScenario(*scenarioFile)
+        if err != nil {
+            fmt.Println("Error loading scenario:", err)
+            return
+        }
+        if *scenarioDataFile != "" {
+            rows, err := LoadCSVData(*scenarioDataFile)
+            if err != nil {
+                fmt.Println("Error loading scenario data:", err)
+                return
+            }
+            scenarioData = newVUDataSource(rows)
+        }
+    }
+
+    outputFileHandle, err := os.OpenFile(*outputFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+    if err != nil {
+        fmt.Println("Error opening output file:", err)
+        return
+    }
+    defer outputFileHandle.Close()
+
+    errorFileHandle, err := os.OpenFile(*errorFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+    if err != nil {
+        fmt.Println("Error opening error log file:", err)
+        return
+    }
+    defer errorFileHandle.Close()
+
+    results := make(chan RequestResult, *maxConcurrentRequests*2)
+    var writeWg sync.WaitGroup
+    writeWg.Add(1)
+    go writeResults(results, outputFileHandle, &writeWg)
+
+    var limiter *rate.Limiter
+    if *targetRate > 0 {
+        limiter = rate.NewLimiter(rate.Limit(*targetRate), int(*targetRate)+1)
+    }
+
+    gateStartConcurrency := *maxConcurrentRequests
+    limiterStartRate := *targetRate
+    if *rampMode != "none" && *rampDuration > 0 {
+        gateStartConcurrency = *rampStartConcurrency
+        limiterStartRate = *rampStartRate
+        if limiter != nil {
+            limiter.SetLimit(rate.Limit(limiterStartRate))
+        }
+    }
+    gate := newConcurrencyGate(gateStartConcurrency)
+
+    runCtx, cancelRun := context.WithCancel(context.Background())
+    defer cancelRun()
+
+    if *rampMode != "none" && *rampDuration > 0 {
+        go runRamp(runCtx, rampConfig{
+            mode:             *rampMode,
+            warmup:           *rampDuration,
+            startConcurrency: *rampStartConcurrency,
+            startRate:        *rampStartRate,
+            steps:            *rampSteps,
+        }, gate, limiter, *maxConcurrentRequests, *targetRate)
+    }
+
+    var wg sync.WaitGroup
+    var inflight int64
+
+    var progress *progressRenderer
+    if *showProgress {
+        progress = newProgressRenderer(stats, &inflight)
+        progress.Start()
+    }
+
+    var control *controlServer
+    if *controlAddr != "" {
+        control = newControlServer(*controlAddr, stats, gate, limiter, &inflight)
+        control.Start()
+    }
+
+    var stopping int32
+    sigCh := make(chan os.Signal, 1)
+    signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+    go func() {
+        <-sigCh
+        fmt.Println("\nShutting down: no new requests will be issued, waiting for in-flight ones to drain...")
+        atomic.StoreInt32(&stopping, 1)
+        cancelRun()
+    }()
+
+    acquire := func() bool {
+        gate.Acquire()
+        if limiter != nil {
+            if err := limiter.Wait(runCtx); err != nil {
+                gate.Release()
+                return false
+            }
+        }
+        return true
+    }
+
+    var dispatch func()
+    if scenario != nil {
+        dispatch = func() {
+            if !acquire() {
+                return
+            }
+            wg.Add(1)
+            ctx, cancel := context.WithTimeout(runCtx, time.Duration(*timeout)*time.Second)
+            go func() {
+                defer wg.Done()
+                defer cancel()
+                defer gate.Release()
+                atomic.AddInt64(&inflight, 1)
+                defer atomic.AddInt64(&inflight, -1)
+                runScenario(ctx, client, scenario, scenarioData.Next(), results, stats, errorFileHandle)
+            }()
+            if *interval > 0 {
+                time.Sleep(time.Duration(*interval) * time.Millisecond)
+            }
+        }
+    } else {
+        dispatch = func() {
+            if !acquire() {
+                return
+            }
+            wg.Add(1)
+            ctx, cancel := context.WithTimeout(runCtx, time.Duration(*timeout)*time.Second)
+            go func() {
+                defer cancel()
+                makeRequest(ctx, client, *method, *url, *body, headers, &wg, gate, results, stats, errorFileHandle, &inflight)
+            }()
+            if *interval > 0 {
+                time.Sleep(time.Duration(*interval) * time.Millisecond)
+            }
+        }
+    }
+
+    if *duration > 0 {
+        deadline := time.Now().Add(*duration)
+        for time.Now().Before(deadline) && atomic.LoadInt32(&stopping) == 0 {
+            dispatch()
+        }
+    } else {
+        for i := 0; i < *numRequests && atomic.LoadInt32(&stopping) == 0; i++ {
+            dispatch()
+        }
+    }
+
+    drained := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(drained)
+    }()
+
+    select {
+    case <-drained:
+        close(results)
+        writeWg.Wait()
+    case <-time.After(*shutdownGrace):
+        fmt.Println("Grace period exceeded; printing partial report without waiting for the stragglers.")
+        cancelRun() // only now: cut off the stragglers we gave up waiting on
+        // Deliberately leave results open: stragglers may still be writing to
+        // it, and the process is about to exit anyway.
+    }
+
+    if progress != nil {
+        progress.Stop()
+    }
+    if control != nil {
+        shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 2*time.Second)
+        control.Shutdown(shutdownCtx)
+        shutdownCancel()
+    }
+
+    snap := stats.Snapshot()
+    summary, err := json.MarshalIndent(snap, "", "  ")
+    if err != nil {
+        fmt.Println("Error marshaling summary:", err)
+        return
+    }
+    if *summaryFile != "" {
+        if err := ioutil.WriteFile(*summaryFile, summary, 0644); err != nil {
+            fmt.Println("Error writing summary file:", err)
+        }
+    } else {
+        fmt.Println("All requests completed")
+        fmt.Println(string(summary))
+    }
+}
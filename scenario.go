@@ -0,0 +1,271 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "sync"
+    "text/template"
+    "time"
+
+    "github.com/google/uuid"
+    "gopkg.in/yaml.v3"
+)
+
+// ExtractRule captures a value out of a step's response body into a
+// variable usable by later steps in the same scenario. Exactly one of
+// JSONPath/Regex should be set.
+type ExtractRule struct {
+    Var      string `yaml:"var" json:"var"`
+    JSONPath string `yaml:"jsonpath,omitempty" json:"jsonpath,omitempty"`
+    Regex    string `yaml:"regex,omitempty" json:"regex,omitempty"`
+}
+
+// ScenarioStep is one request in a virtual-user sequence.
+type ScenarioStep struct {
+    Name           string            `yaml:"name,omitempty" json:"name,omitempty"`
+    Method         string            `yaml:"method" json:"method"`
+    URL            string            `yaml:"url" json:"url"`
+    Headers        map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+    Body           string            `yaml:"body,omitempty" json:"body,omitempty"`
+    ExpectStatus   int               `yaml:"expect_status,omitempty" json:"expect_status,omitempty"`
+    Extract        []ExtractRule     `yaml:"extract,omitempty" json:"extract,omitempty"`
+}
+
+// Scenario is a named sequence of steps a virtual user iterates through
+// repeatedly, as opposed to hammering a single endpoint.
+type Scenario struct {
+    Name  string         `yaml:"name,omitempty" json:"name,omitempty"`
+    Steps []ScenarioStep `yaml:"steps" json:"steps"`
+}
+
+// LoadScenario reads a scenario file, dispatching to YAML or JSON based on
+// its extension.
+func LoadScenario(path string) (*Scenario, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("reading scenario file: %w", err)
+    }
+
+    var scenario Scenario
+    switch ext := strings.ToLower(filepath.Ext(path)); ext {
+    case ".yaml", ".yml":
+        err = yaml.Unmarshal(data, &scenario)
+    case ".json":
+        err = json.Unmarshal(data, &scenario)
+    default:
+        return nil, fmt.Errorf("unsupported scenario file extension %q (want .yaml, .yml or .json)", ext)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("parsing scenario file: %w", err)
+    }
+    if len(scenario.Steps) == 0 {
+        return nil, fmt.Errorf("scenario %s has no steps", path)
+    }
+    return &scenario, nil
+}
+
+// LoadCSVData reads a CSV data source, one row per virtual-user iteration,
+// keyed by the header row's column names.
+func LoadCSVData(path string) ([]map[string]string, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("opening CSV data file: %w", err)
+    }
+    defer f.Close()
+
+    reader := csv.NewReader(f)
+    records, err := reader.ReadAll()
+    if err != nil {
+        return nil, fmt.Errorf("parsing CSV data file: %w", err)
+    }
+    if len(records) == 0 {
+        return nil, nil
+    }
+
+    header := records[0]
+    rows := make([]map[string]string, 0, len(records)-1)
+    for _, record := range records[1:] {
+        row := make(map[string]string, len(header))
+        for i, col := range header {
+            if i < len(record) {
+                row[col] = record[i]
+            }
+        }
+        rows = append(rows, row)
+    }
+    return rows, nil
+}
+
+// templateFuncs exposes small helpers to scenario templates, on top of the
+// CSV row and extracted variables.
+var templateFuncs = template.FuncMap{
+    "randInt": func(min, max int) int {
+        if max <= min {
+            return min
+        }
+        return min + rand.Intn(max-min)
+    },
+    "uuid": func() string {
+        return uuid.NewString()
+    },
+}
+
+func expandTemplate(text string, vars map[string]string) (string, error) {
+    if !strings.Contains(text, "{{") {
+        return text, nil
+    }
+    tmpl, err := template.New("scenario").Funcs(templateFuncs).Parse(text)
+    if err != nil {
+        return "", fmt.Errorf("parsing template %q: %w", text, err)
+    }
+    var buf bytes.Buffer
+    if err := tmpl.Execute(&buf, vars); err != nil {
+        return "", fmt.Errorf("executing template %q: %w", text, err)
+    }
+    return buf.String(), nil
+}
+
+// extractValue pulls a value out of a response body using either a simple
+// dot-path JSON lookup (e.g. "data.token" or "$.data.token") or a regex
+// with one capture group.
+func extractValue(rule ExtractRule, body []byte) (string, error) {
+    if rule.Regex != "" {
+        re, err := regexp.Compile(rule.Regex)
+        if err != nil {
+            return "", fmt.Errorf("compiling regex %q: %w", rule.Regex, err)
+        }
+        matches := re.FindSubmatch(body)
+        if len(matches) < 2 {
+            return "", fmt.Errorf("regex %q did not match", rule.Regex)
+        }
+        return string(matches[1]), nil
+    }
+
+    if rule.JSONPath != "" {
+        var doc interface{}
+        if err := json.Unmarshal(body, &doc); err != nil {
+            return "", fmt.Errorf("response is not valid JSON: %w", err)
+        }
+        path := strings.TrimPrefix(rule.JSONPath, "$.")
+        path = strings.TrimPrefix(path, "$")
+        value := doc
+        for _, segment := range strings.Split(path, ".") {
+            if segment == "" {
+                continue
+            }
+            obj, ok := value.(map[string]interface{})
+            if !ok {
+                return "", fmt.Errorf("jsonpath %q: %q is not an object", rule.JSONPath, segment)
+            }
+            value, ok = obj[segment]
+            if !ok {
+                return "", fmt.Errorf("jsonpath %q: field %q not found", rule.JSONPath, segment)
+            }
+        }
+        return fmt.Sprintf("%v", value), nil
+    }
+
+    return "", fmt.Errorf("extract rule %q has neither jsonpath nor regex", rule.Var)
+}
+
+// runScenario drives one virtual-user iteration through every step of the
+// scenario, threading extracted variables from earlier steps into later
+// ones, and records each step as an ordinary RequestResult.
+func runScenario(ctx context.Context, client Doer, scenario *Scenario, vars map[string]string, results chan<- RequestResult, stats *Statistics, logFile *os.File) {
+    for _, step := range scenario.Steps {
+        url, err := expandTemplate(step.URL, vars)
+        if err != nil {
+            logError(logFile, err.Error())
+            stats.IncrementFailures()
+            continue
+        }
+        body, err := expandTemplate(step.Body, vars)
+        if err != nil {
+            logError(logFile, err.Error())
+            stats.IncrementFailures()
+            continue
+        }
+        headers := make(map[string]string, len(step.Headers))
+        for key, value := range step.Headers {
+            expanded, err := expandTemplate(value, vars)
+            if err != nil {
+                logError(logFile, err.Error())
+                continue
+            }
+            headers[key] = expanded
+        }
+
+        startTime := time.Now()
+        resp, err := client.Do(ctx, step.Method, url, headers, []byte(body))
+        duration := time.Since(startTime)
+
+        if err != nil {
+            logError(logFile, fmt.Sprintf("scenario step %q: %v", step.Name, err))
+            results <- RequestResult{Error: err.Error()}
+            stats.IncrementFailures()
+            continue
+        }
+
+        result := RequestResult{Status: resp.Status, Duration: duration, ResponseLength: len(resp.Body)}
+        if step.ExpectStatus != 0 && resp.StatusCode != step.ExpectStatus {
+            result.Error = fmt.Sprintf("expected status %d, got %d", step.ExpectStatus, resp.StatusCode)
+            stats.IncrementFailures()
+        } else {
+            stats.IncrementRequests()
+        }
+        results <- result
+        stats.AddDuration(duration)
+        stats.AddBytes(int64(len(resp.Body)))
+        stats.RecordStatus(resp.StatusCode)
+
+        for _, rule := range step.Extract {
+            value, err := extractValue(rule, resp.Body)
+            if err != nil {
+                logError(logFile, fmt.Sprintf("scenario step %q: %v", step.Name, err))
+                continue
+            }
+            vars[rule.Var] = value
+        }
+    }
+}
+
+// vuDataSource hands out CSV rows to virtual users round-robin, so
+// concurrent VUs don't race over which row they each get.
+type vuDataSource struct {
+    mu   sync.Mutex
+    rows []map[string]string
+    next int
+}
+
+func newVUDataSource(rows []map[string]string) *vuDataSource {
+    return &vuDataSource{rows: rows}
+}
+
+func (d *vuDataSource) Next() map[string]string {
+    vars := make(map[string]string)
+    if d == nil || len(d.rows) == 0 {
+        return vars
+    }
+    d.mu.Lock()
+    row := d.rows[d.next%len(d.rows)]
+    d.next++
+    d.mu.Unlock()
+    for k, v := range row {
+        vars[k] = v
+    }
+    return vars
+}
+
+func init() {
+    // Seed the package-level rand source used by the randInt template
+    // helper; math/rand's default source is deterministic otherwise.
+    rand.Seed(time.Now().UnixNano())
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "os"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Statistics is a concurrent, allocation-free latency recorder. Durations are
+// bucketed into a fixed exponential histogram (microseconds to minutes) with
+// atomic per-bucket counters so AddDuration never takes a lock on the hot
+// path; percentiles are derived from the histogram via cumulative sum at
+// query time. Running mean/stddev use Welford's online algorithm, which is
+// the only part that still needs the mutex.
+type Statistics struct {
+    mu sync.Mutex
+
+    requestCount int64
+    failureCount int64
+    totalBytes   int64
+
+    sampleCount int64 // samples folded into mean/m2, maintained under mu (not the same as requestCount)
+    mean        float64
+    m2          float64
+    minDur      time.Duration
+    maxDur      time.Duration
+
+    buckets     [histogramBuckets]int64
+    statusCodes map[int]int64
+
+    startedAt time.Time
+    transport *connCounter
+}
+
+// AttachTransport lets the report include socket-level throughput (bytes
+// in/out, connections opened) alongside the latency statistics, shared
+// across whichever Doer backend is in use.
+func (s *Statistics) AttachTransport(counter *connCounter) {
+    s.transport = counter
+}
+
+const (
+    // histogramBaseNanos is the width of bucket 0, in nanoseconds (1us).
+    histogramBaseNanos = int64(time.Microsecond)
+    // histogramBuckets covers 1us up to ~9.4 years with a factor-of-1.3
+    // exponential growth per bucket. Worst-case relative error on a reported
+    // percentile is bounded by (histogramGrowth - 1), i.e. ~30% here, without
+    // storing every sample.
+    histogramBuckets  = 128
+    histogramGrowth   = 1.3
+)
+
+func NewStatistics() *Statistics {
+    return &Statistics{
+        statusCodes: make(map[int]int64),
+        startedAt:   time.Now(),
+        minDur:      time.Duration(math.MaxInt64),
+    }
+}
+
+func bucketForDuration(d time.Duration) int {
+    if d <= 0 {
+        return 0
+    }
+    nanos := float64(d)
+    base := float64(histogramBaseNanos)
+    if nanos <= base {
+        return 0
+    }
+    idx := int(math.Log(nanos/base) / math.Log(histogramGrowth))
+    if idx >= histogramBuckets {
+        idx = histogramBuckets - 1
+    }
+    return idx
+}
+
+func durationForBucket(idx int) time.Duration {
+    return time.Duration(float64(histogramBaseNanos) * math.Pow(histogramGrowth, float64(idx)))
+}
+
+// AddDuration records a completed request's latency. Safe for concurrent use.
+func (s *Statistics) AddDuration(duration time.Duration) {
+    atomic.AddInt64(&s.buckets[bucketForDuration(duration)], 1)
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if duration < s.minDur {
+        s.minDur = duration
+    }
+    if duration > s.maxDur {
+        s.maxDur = duration
+    }
+    s.sampleCount++
+    delta := float64(duration) - s.mean
+    s.mean += delta / float64(s.sampleCount)
+    s.m2 += delta * (float64(duration) - s.mean)
+}
+
+func (s *Statistics) IncrementRequests() {
+    atomic.AddInt64(&s.requestCount, 1)
+}
+
+func (s *Statistics) IncrementFailures() {
+    atomic.AddInt64(&s.failureCount, 1)
+}
+
+func (s *Statistics) AddBytes(n int64) {
+    atomic.AddInt64(&s.totalBytes, n)
+}
+
+func (s *Statistics) RecordStatus(code int) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.statusCodes[code]++
+}
+
+func (s *Statistics) RequestCount() int64 { return atomic.LoadInt64(&s.requestCount) }
+func (s *Statistics) FailureCount() int64 { return atomic.LoadInt64(&s.failureCount) }
+
+func (s *Statistics) AverageDuration() time.Duration {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return time.Duration(s.mean)
+}
+
+func (s *Statistics) StdDev() time.Duration {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.sampleCount < 2 {
+        return 0
+    }
+    return time.Duration(math.Sqrt(s.m2 / float64(s.sampleCount-1)))
+}
+
+// Percentile returns the latency below which p fraction of requests fell,
+// e.g. Percentile(0.99) is p99. p must be in (0, 1].
+func (s *Statistics) Percentile(p float64) time.Duration {
+    var total int64
+    for i := 0; i < histogramBuckets; i++ {
+        total += atomic.LoadInt64(&s.buckets[i])
+    }
+    if total == 0 {
+        return 0
+    }
+
+    target := int64(math.Ceil(p * float64(total)))
+    var cumulative int64
+    for i := 0; i < histogramBuckets; i++ {
+        cumulative += atomic.LoadInt64(&s.buckets[i])
+        if cumulative >= target {
+            return durationForBucket(i)
+        }
+    }
+    return durationForBucket(histogramBuckets - 1)
+}
+
+// Snapshot is a point-in-time, JSON-serializable view of the statistics,
+// used both by the final summary and by the live progress renderer.
+type Snapshot struct {
+    RequestCount int64            `json:"request_count"`
+    FailureCount int64            `json:"failure_count"`
+    TotalBytes   int64            `json:"total_bytes"`
+    Min          time.Duration    `json:"min"`
+    Max          time.Duration    `json:"max"`
+    Mean         time.Duration    `json:"mean"`
+    StdDev       time.Duration    `json:"stddev"`
+    P50          time.Duration    `json:"p50"`
+    P90          time.Duration    `json:"p90"`
+    P95          time.Duration    `json:"p95"`
+    P99          time.Duration    `json:"p99"`
+    P999         time.Duration    `json:"p999"`
+    StatusCodes  map[int]int64    `json:"status_codes"`
+    ElapsedSecs  float64          `json:"elapsed_seconds"`
+    RPS          float64          `json:"requests_per_second"`
+
+    BytesRead    int64   `json:"bytes_read,omitempty"`
+    BytesWritten int64   `json:"bytes_written,omitempty"`
+    Connections  int64   `json:"connections_opened,omitempty"`
+    MBPSIn       float64 `json:"mb_per_sec_in,omitempty"`
+    MBPSOut      float64 `json:"mb_per_sec_out,omitempty"`
+}
+
+func (s *Statistics) Snapshot() Snapshot {
+    s.mu.Lock()
+    minDur := s.minDur
+    if minDur == time.Duration(math.MaxInt64) {
+        minDur = 0
+    }
+    maxDur := s.maxDur
+    statusCodes := make(map[int]int64, len(s.statusCodes))
+    for code, count := range s.statusCodes {
+        statusCodes[code] = count
+    }
+    s.mu.Unlock()
+
+    elapsed := time.Since(s.startedAt).Seconds()
+    requestCount := s.RequestCount()
+    rps := 0.0
+    if elapsed > 0 {
+        rps = float64(requestCount) / elapsed
+    }
+
+    snap := Snapshot{
+        RequestCount: requestCount,
+        FailureCount: s.FailureCount(),
+        TotalBytes:   atomic.LoadInt64(&s.totalBytes),
+        Min:          minDur,
+        Max:          maxDur,
+        Mean:         s.AverageDuration(),
+        StdDev:       s.StdDev(),
+        P50:          s.Percentile(0.50),
+        P90:          s.Percentile(0.90),
+        P95:          s.Percentile(0.95),
+        P99:          s.Percentile(0.99),
+        P999:         s.Percentile(0.999),
+        StatusCodes:  statusCodes,
+        ElapsedSecs:  elapsed,
+        RPS:          rps,
+    }
+
+    if s.transport != nil {
+        snap.BytesRead = s.transport.BytesRead()
+        snap.BytesWritten = s.transport.BytesWritten()
+        snap.Connections = s.transport.ConnsOpened()
+        if elapsed > 0 {
+            const mb = 1024 * 1024
+            snap.MBPSIn = float64(snap.BytesRead) / mb / elapsed
+            snap.MBPSOut = float64(snap.BytesWritten) / mb / elapsed
+        }
+    }
+
+    return snap
+}
+
+// progressRenderer prints a refreshing single-line summary of the run to
+// stderr roughly every 500ms, so users get live RPS/error-rate/p99 feedback
+// on long -duration runs without waiting for the final report.
+type progressRenderer struct {
+    stats    *Statistics
+    inflight *int64
+    stop     chan struct{}
+    done     chan struct{}
+}
+
+func newProgressRenderer(stats *Statistics, inflight *int64) *progressRenderer {
+    return &progressRenderer{
+        stats:    stats,
+        inflight: inflight,
+        stop:     make(chan struct{}),
+        done:     make(chan struct{}),
+    }
+}
+
+func (p *progressRenderer) Start() {
+    go func() {
+        defer close(p.done)
+        ticker := time.NewTicker(500 * time.Millisecond)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                p.render()
+            case <-p.stop:
+                p.render()
+                fmt.Fprintln(os.Stderr)
+                return
+            }
+        }
+    }()
+}
+
+func (p *progressRenderer) Stop() {
+    close(p.stop)
+    <-p.done
+}
+
+func (p *progressRenderer) render() {
+    snap := p.stats.Snapshot()
+    errRate := 0.0
+    if snap.RequestCount+snap.FailureCount > 0 {
+        errRate = float64(snap.FailureCount) / float64(snap.RequestCount+snap.FailureCount) * 100
+    }
+    fmt.Fprintf(os.Stderr, "\rrps=%.1f inflight=%d errors=%.2f%% p99=%v        ",
+        snap.RPS, atomic.LoadInt64(p.inflight), errRate, snap.P99)
+}